@@ -0,0 +1,110 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// accessLogOutput is where Combined Log Format access log lines are written. It defaults to
+// stdout; use SetAccessLogOutput to point it at a file or any other io.Writer instead.
+var accessLogOutput io.Writer = os.Stdout
+
+// SetAccessLogOutput changes where access log lines are written.
+func SetAccessLogOutput(w io.Writer) {
+	accessLogOutput = w
+}
+
+// responseRecorder wraps an http.ResponseWriter to capture the status code and byte count
+// written, neither of which http.ResponseWriter exposes after the fact.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// newRequestID returns a random request id, used when a request doesn't already carry one in
+// X-Request-ID from an upstream proxy.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withAccessLog wraps next so that every request gets an X-Request-ID (propagated from the
+// incoming request if it has one, generated otherwise), and, once next has written its response,
+// one Apache Combined Log Format line is emitted to accessLogOutput.
+func withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		rr := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next(rr, r)
+
+		logger.Info("request",
+			F("request_id", requestID),
+			F("method", r.Method),
+			F("path", r.URL.Path),
+			F("status", rr.status),
+			F("bytes", rr.bytes),
+			F("latency", time.Since(start)),
+		)
+
+		fmt.Fprintln(accessLogOutput, combinedLogLine(r, rr.status, rr.bytes, start))
+	}
+}
+
+// combinedLogLine formats one line of Apache Combined Log Format for r, given the status and byte
+// count its response ended up with and when it started.
+func combinedLogLine(r *http.Request, status int, bytes int, start time.Time) string {
+	host := r.RemoteAddr
+	if i := lastIndexByte(host, ':'); i != -1 {
+		host = host[:i]
+	}
+
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d "%s" "%s"`,
+		host,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.URL.RequestURI(), r.Proto,
+		status, bytes,
+		r.Referer(), r.UserAgent(),
+	)
+}
+
+// lastIndexByte returns the index of the last occurrence of c in s, or -1.
+func lastIndexByte(s string, c byte) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
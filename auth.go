@@ -0,0 +1,92 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// loginDestPrefix is the path prefix Route() redirects unauthenticated users to, with the
+// original destination base64-encoded onto the end of it. See RequireAuth.
+const loginDestPrefix = "/login/dest/"
+
+// LoginHandler handles both the login form (a plain GET) and its submission (a POST with username
+// and password fields). On success it clears the user's password hash before storing it in the
+// session, runs AppSetup.PostLoginHook if one is set, and redirects to the dest encoded onto the
+// URL by RequireAuth/Route, or to "/" if there wasn't one. On failure it re-renders the form with
+// an "error" view value.
+func LoginHandler(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+	var hr HandlerResponse
+	hr.Init()
+
+	if r.Method != "POST" {
+		return hr, nil
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	user := a.Setup.GetUser(username, a)
+	if user == nil || !user.TestPassword(password, a) {
+		hr.View["error"] = "Invalid username or password."
+		return hr, nil
+	}
+
+	user.ClearPasswordHash()
+	rs.Session.Values["user"] = user
+
+	if a.Setup.PostLoginHook != nil {
+		a.Setup.PostLoginHook(user, a)
+	}
+
+	hr.Redirect = "/"
+	if dest, ok := decodeDest(strings.TrimPrefix(r.URL.Path, loginDestPrefix)); ok {
+		hr.Redirect = dest
+	}
+
+	return hr, nil
+}
+
+// LogoutHandler clears the logged-in user from the session and redirects to "/".
+func LogoutHandler(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+	delete(rs.Session.Values, "user")
+
+	var hr HandlerResponse
+	hr.Redirect = "/"
+	return hr, nil
+}
+
+// RequireAuth is a Middleware that redirects to the login page, with the current path encoded as
+// the dest to return to, unless a user is already logged in. Unlike RoleMiddleware it doesn't care
+// which role the user has, only that they're logged in at all.
+func RequireAuth(next Handler) Handler {
+	return func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		if rs.Session.Values["user"] == nil {
+			var hr HandlerResponse
+			hr.Redirect = loginDestPrefix + base64.URLEncoding.EncodeToString([]byte(r.URL.Path))
+			return hr, nil
+		}
+		return next(r, a, rs)
+	}
+}
+
+// decodeDest base64-decodes a dest produced by RequireAuth or Route's own role check, rejecting
+// anything that isn't a safe, same-site, root-relative path, so a crafted dest can't be used to
+// redirect a user off-site after login.
+func decodeDest(encoded string) (string, bool) {
+	raw, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+
+	dest := string(raw)
+	if dest == "" || !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") || strings.HasPrefix(dest, "/\\") || strings.Contains(dest, "://") {
+		return "", false
+	}
+
+	return dest, true
+}
@@ -0,0 +1,50 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func encodeDest(dest string) string {
+	return base64.URLEncoding.EncodeToString([]byte(dest))
+}
+
+func TestDecodeDest(t *testing.T) {
+	cases := []struct {
+		name    string
+		dest    string
+		wantOK  bool
+		wantVal string
+	}{
+		{"root relative path", "/account", true, "/account"},
+		{"root relative path with query", "/account?tab=profile", true, "/account?tab=profile"},
+		{"empty", "", false, ""},
+		{"not root relative", "account", false, ""},
+		{"protocol relative", "//evil.com", false, ""},
+		{"absolute url", "https://evil.com", false, ""},
+		{"absolute url mixed case scheme", "HTTPS://evil.com", false, ""},
+		{"backslash host", "/\\evil.com", false, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := decodeDest(encodeDest(c.dest))
+			if ok != c.wantOK {
+				t.Fatalf("decodeDest(%q) ok = %v, want %v", c.dest, ok, c.wantOK)
+			}
+			if ok && got != c.wantVal {
+				t.Fatalf("decodeDest(%q) = %q, want %q", c.dest, got, c.wantVal)
+			}
+		})
+	}
+}
+
+func TestDecodeDestRejectsInvalidBase64(t *testing.T) {
+	if _, ok := decodeDest("not valid base64!!"); ok {
+		t.Fatal("expected invalid base64 to be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// sawsij-migrate applies any pending db/migrations/<schema>/NNN_description.sql files to every
+// schema listed in an app's etc/config.yaml, bringing each up to the version the config expects.
+//
+// Usage:
+//
+//	sawsij-migrate /path/to/app
+package main
+
+import (
+	"database/sql"
+	_ "github.com/bmizerany/pq"
+	"github.com/kylelemons/go-gypsy/yaml"
+	"github.com/jangocheng/sawsij"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: sawsij-migrate /path/to/app")
+	}
+	basePath := os.Args[1]
+
+	c, err := yaml.ReadFile(basePath + "/etc/config.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	driver, err := c.Get("database.driver")
+	if err != nil {
+		log.Fatal(err)
+	}
+	connect, err := c.Get("database.connect")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open(driver, connect)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schemasN, err := yaml.Child(c.Root, ".database.schemas")
+	if err != nil || schemasN == nil {
+		log.Fatal("No schemas defined in config.yaml")
+	}
+	schemas := schemasN.(yaml.Map)
+
+	for schema, version := range schemas {
+		target, err := strconv.ParseInt(strings.TrimSpace(string(version.(yaml.Scalar))), 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Migrating schema %q to version %v", schema, target)
+		if err := sawsij.MigrateSchema(db, basePath, string(schema), target); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Print("Migrations complete.")
+}
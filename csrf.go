@@ -0,0 +1,89 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"text/template"
+)
+
+// csrfSessionKey is the key under which the per-session CSRF token is stored in session.Values.
+const csrfSessionKey = "_csrf_token"
+
+// csrfFormField is the form field name checked for the CSRF token on state-changing requests.
+const csrfFormField = "csrf_token"
+
+// csrfHeaderName is the request header checked for the CSRF token when the form field isn't present.
+const csrfHeaderName = "X-CSRF-Token"
+
+// newCSRFToken generates a random, base64-encoded CSRF token.
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// getCSRFToken returns the CSRF token for the given session, generating and storing one if it
+// doesn't already have one.
+func getCSRFToken(session *sessions.Session) string {
+	if session == nil {
+		return ""
+	}
+	if t, ok := session.Values[csrfSessionKey].(string); ok && t != "" {
+		return t
+	}
+	t := newCSRFToken()
+	session.Values[csrfSessionKey] = t
+	return t
+}
+
+// csrfFuncMap returns the csrfField/csrfToken template functions bound to session. Route() clones
+// parsedTemplate and applies this per request, rather than reaching for a shared package-level
+// session: text/template's Funcs map is part of the template itself, and parsedTemplate is one
+// shared *template.Template handling every concurrent request, so binding the funcs straight to a
+// package variable would let one request's template render with another request's CSRF token.
+func csrfFuncMap(session *sessions.Session) template.FuncMap {
+	return template.FuncMap{
+		"csrfField": func() string {
+			token := getCSRFToken(session)
+			return fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, csrfFormField, template.HTMLEscapeString(token))
+		},
+		"csrfToken": func() string {
+			return getCSRFToken(session)
+		},
+	}
+}
+
+// isSafeMethod reports whether r's method is one that Route() never CSRF-checks.
+func isSafeMethod(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	return false
+}
+
+// checkCSRF validates the CSRF token on a state-changing request against the token stored in
+// session. The token may be supplied as a form field or as the X-CSRF-Token header. It returns
+// false if the request should be rejected. The comparison is constant-time so that a forged token
+// can't be brute-forced a byte at a time by timing how long the check takes to fail.
+func checkCSRF(r *http.Request, session *sessions.Session) bool {
+	expected := getCSRFToken(session)
+	if expected == "" {
+		return false
+	}
+
+	supplied := r.FormValue(csrfFormField)
+	if supplied == "" {
+		supplied = r.Header.Get(csrfHeaderName)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(supplied), []byte(expected)) == 1
+}
@@ -0,0 +1,164 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"github.com/gorilla/sessions"
+	"github.com/kylelemons/go-gypsy/yaml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func newTestSession() *sessions.Session {
+	return &sessions.Session{Values: make(map[interface{}]interface{})}
+}
+
+func TestGetCSRFTokenIsStableAndNonEmpty(t *testing.T) {
+	s := newTestSession()
+
+	token := getCSRFToken(s)
+	if token == "" {
+		t.Fatal("expected a non-empty token")
+	}
+	if again := getCSRFToken(s); again != token {
+		t.Fatalf("token changed between calls: %q != %q", again, token)
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	s := newTestSession()
+	token := getCSRFToken(s)
+
+	ok, _ := http.NewRequest("POST", "/", strings.NewReader("csrf_token="+token))
+	ok.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !checkCSRF(ok, s) {
+		t.Fatal("expected a matching csrf_token form value to pass")
+	}
+
+	bad, _ := http.NewRequest("POST", "/", strings.NewReader("csrf_token=wrong"))
+	bad.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if checkCSRF(bad, s) {
+		t.Fatal("expected a mismatched csrf_token to be rejected")
+	}
+
+	header, _ := http.NewRequest("POST", "/", nil)
+	header.Header.Set("X-CSRF-Token", token)
+	if !checkCSRF(header, s) {
+		t.Fatal("expected a matching X-CSRF-Token header to pass")
+	}
+}
+
+// TestCSRFFuncMapRendersPlainString exercises csrfFuncMap the same way Route() does: parsed and
+// executed through text/template, and checks that csrfField renders the complete hidden input
+// element carrying the session's token.
+func TestCSRFFuncMapRendersPlainString(t *testing.T) {
+	s := newTestSession()
+
+	tmpl, err := template.New("t").Funcs(csrfFuncMap(s)).Parse(`{{csrfField}}`)
+	if err != nil {
+		t.Fatalf("parsing template: %v", err)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatalf("executing template: %v", err)
+	}
+
+	want := `<input type="hidden" name="csrf_token" value="` + getCSRFToken(s) + `">`
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// TestCSRFFuncMapIsolatedPerSession guards against reintroducing a shared package-level session:
+// two independently-bound func maps must never see each other's token.
+func TestCSRFFuncMapIsolatedPerSession(t *testing.T) {
+	a := newTestSession()
+	b := newTestSession()
+
+	fnA := csrfFuncMap(a)["csrfToken"].(func() string)
+	fnB := csrfFuncMap(b)["csrfToken"].(func() string)
+
+	if fnA() == fnB() {
+		t.Fatal("two different sessions produced the same csrf token")
+	}
+}
+
+// TestRoutePersistsCSRFTokenBeforeFirstSave is the end-to-end regression test for the ordering bug
+// where a session's first render minted its CSRF token (lazily, inside csrfFuncMap, when the
+// template executed) after Route() had already saved and signed that session into the response
+// cookie. That left the rendered token unable to round-trip: the next POST would decode a session
+// with no stored token, checkCSRF would mint a different one to compare against, and the request
+// would always be rejected. Route() must make sure a token exists before the session is saved.
+func TestRoutePersistsCSRFTokenBeforeFirstSave(t *testing.T) {
+	savedAppScope, savedStore, savedTemplate := appScope, store, parsedTemplate
+	defer func() { appScope, store, parsedTemplate = savedAppScope, savedStore, savedTemplate }()
+
+	appScope = &AppScope{Config: yaml.Config("server: {}\n")}
+	store = sessions.NewCookieStore([]byte("test-signing-key-0123456789"))
+
+	tmpl, err := template.New("dummy").Delims("<%", "%>").Funcs(GetFuncMap()).
+		New("csrf-round-trip-test.html").Parse(`<form><%csrfField%></form>`)
+	if err != nil {
+		t.Fatalf("parsing test template: %v", err)
+	}
+	parsedTemplate = tmpl
+
+	var handlerSawPost bool
+	Route(RouteConfig{
+		Pattern: "/csrf-round-trip-test",
+		Handler: func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+			var hr HandlerResponse
+			hr.Init()
+			handlerSawPost = handlerSawPost || r.Method == "POST"
+			return hr, nil
+		},
+	})
+
+	getRec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(getRec, httptest.NewRequest("GET", "/csrf-round-trip-test", nil))
+	if getRec.Code != http.StatusOK {
+		t.Fatalf("GET: status %d, body %q", getRec.Code, getRec.Body.String())
+	}
+
+	const marker = `name="csrf_token" value="`
+	body := getRec.Body.String()
+	i := strings.Index(body, marker)
+	if i == -1 {
+		t.Fatalf("no csrf_token field in rendered body: %q", body)
+	}
+	i += len(marker)
+	j := strings.Index(body[i:], `"`)
+	if j == -1 {
+		t.Fatalf("malformed csrf_token field in rendered body: %q", body)
+	}
+	token := body[i : i+j]
+
+	var sessionCookie *http.Cookie
+	for _, c := range getRec.Result().Cookies() {
+		if c.Name == "session" {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatalf("no session cookie set by the first render, got %+v", getRec.Result().Cookies())
+	}
+
+	postReq := httptest.NewRequest("POST", "/csrf-round-trip-test", strings.NewReader("csrf_token="+token))
+	postReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	postReq.AddCookie(sessionCookie)
+	postRec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(postRec, postReq)
+
+	if postRec.Code == http.StatusForbidden {
+		t.Fatalf("POST using the token rendered by the first GET was rejected: %d %q", postRec.Code, postRec.Body.String())
+	}
+	if !handlerSawPost {
+		t.Fatal("handler never saw the POST")
+	}
+}
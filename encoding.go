@@ -0,0 +1,156 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The return types a route can render. RT_HTML is the zero value, so a route that doesn't care
+// about this still gets the original template-based behaviour.
+const (
+	RT_HTML = iota
+	RT_JSON
+	RT_XML
+)
+
+// rtMimeTypes maps a return type to the MIME type used both for content negotiation and for the
+// Content-Type header written in the response.
+var rtMimeTypes = map[int]string{
+	RT_HTML: "text/html",
+	RT_JSON: "application/json",
+	RT_XML:  "application/xml",
+}
+
+// Encoder marshals v and writes it to w. It's the shape of func(io.Writer, interface{}) error,
+// matching json.NewEncoder(w).Encode and xml.NewEncoder(w).Encode.
+type Encoder func(io.Writer, interface{}) error
+
+// encoders holds the Encoder registered for each MIME type. RegisterEncoder adds to or replaces
+// entries in this registry; Route() renders RT_JSON and RT_XML responses with the Encoder found
+// here under that return type's MIME type in rtMimeTypes.
+var encoders = map[string]Encoder{
+	"application/json": func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+	"application/xml": func(w io.Writer, v interface{}) error {
+		// encoding/xml can't marshal a map directly (the usual shape of HandlerResponse.View
+		// once more than one value has been set), so route it through xmlMap instead.
+		if m, ok := v.(map[string]interface{}); ok {
+			v = xmlMap(m)
+		}
+		return xml.NewEncoder(w).Encode(v)
+	},
+}
+
+// xmlMap adapts a map[string]interface{} — the shape of HandlerResponse.View whenever a handler
+// sets more than one view value — into something encoding/xml can marshal, since it has no
+// built-in support for maps. Keys are sorted so the output is deterministic.
+type xmlMap map[string]interface{}
+
+// MarshalXML writes m as a <response> element containing one child element per map entry, named
+// after its key.
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "response"}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+// RegisterEncoder registers fn as the Encoder for mime, replacing whatever was registered for it
+// before. Registering under "application/json" or "application/xml" swaps out the built-in
+// encoding for RT_JSON or RT_XML responses. Route() looks up only those two MIME types, so an
+// Encoder registered under any other MIME type is stored but never used to render a response.
+func RegisterEncoder(mime string, fn func(io.Writer, interface{}) error) {
+	encoders[mime] = Encoder(fn)
+}
+
+// GetReturnType works out which format a request wants based on the "/json" or "/xml" prefix on
+// path, returning the remainder of the path with that prefix stripped. If neither prefix is
+// present it returns RT_HTML and the path unchanged.
+func GetReturnType(path string) (returnType int, restOfUrl string) {
+	switch {
+	case strings.HasPrefix(path, "/json"):
+		return RT_JSON, strings.TrimPrefix(path, "/json")
+	case strings.HasPrefix(path, "/xml"):
+		return RT_XML, strings.TrimPrefix(path, "/xml")
+	default:
+		return RT_HTML, path
+	}
+}
+
+// GetReturnTypeForRequest is a variant of GetReturnType that also considers content negotiation.
+// If r's URL has a "/json" or "/xml" prefix, that always wins, exactly as GetReturnType behaves on
+// its own. Otherwise, if defaultReturnType is non-zero (RouteConfig.DefaultReturnType), it's used
+// as-is. Failing that, the Accept header is consulted, preferring (in order) application/json,
+// application/xml and text/html; an empty or "*/*" Accept header falls back to RT_HTML.
+func GetReturnTypeForRequest(r *http.Request, defaultReturnType int) (returnType int, restOfUrl string) {
+	returnType, restOfUrl = GetReturnType(r.URL.Path)
+	if returnType != RT_HTML {
+		return returnType, restOfUrl
+	}
+
+	if defaultReturnType != RT_HTML {
+		return defaultReturnType, restOfUrl
+	}
+
+	return negotiateReturnType(r.Header.Get("Accept")), restOfUrl
+}
+
+// negotiateReturnType picks a return type from an Accept header, preferring JSON, then XML, then
+// HTML, regardless of the order the client listed them in. It ignores quality values; that's
+// enough to satisfy the common cases (a single Accept value, or a browser's "text/html, */*").
+func negotiateReturnType(accept string) int {
+	if accept == "" {
+		return RT_HTML
+	}
+
+	sawXML := false
+	sawHTML := false
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return RT_JSON
+		case "application/xml", "text/xml":
+			sawXML = true
+		case "text/html":
+			sawHTML = true
+		}
+	}
+
+	if sawXML {
+		return RT_XML
+	}
+	if sawHTML {
+		return RT_HTML
+	}
+
+	return RT_HTML
+}
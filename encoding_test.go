@@ -0,0 +1,71 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+// TestXMLEncoderHandlesMultiKeyView is the round-trip the RT_XML branch needs for any route whose
+// HandlerResponse.View has more than one entry (or none): encoding/xml can't marshal a plain map,
+// so the "application/xml" encoder must route it through xmlMap instead.
+func TestXMLEncoderHandlesMultiKeyView(t *testing.T) {
+	view := map[string]interface{}{
+		"name": "Ada",
+		"id":   42,
+	}
+
+	var buf bytes.Buffer
+	if err := encoders["application/xml"](&buf, view); err != nil {
+		t.Fatalf("encoding multi-key view: %v", err)
+	}
+
+	var decoded struct {
+		XMLName xml.Name `xml:"response"`
+		Name    string   `xml:"name"`
+		Id      int      `xml:"id"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding encoded xml: %v", err)
+	}
+
+	if decoded.Name != "Ada" || decoded.Id != 42 {
+		t.Fatalf("got %+v, want name=Ada id=42", decoded)
+	}
+}
+
+func TestXMLEncoderHandlesEmptyView(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encoders["application/xml"](&buf, map[string]interface{}{}); err != nil {
+		t.Fatalf("encoding empty view: %v", err)
+	}
+}
+
+// TestNegotiateReturnTypePrefersJSONThenXMLThenHTML guards against negotiateReturnType picking
+// whichever of application/json, application/xml and text/html comes first in the header rather
+// than honouring its documented preference order regardless of position.
+func TestNegotiateReturnTypePrefersJSONThenXMLThenHTML(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   int
+	}{
+		{"application/xml, application/json", RT_JSON},
+		{"text/html, application/json", RT_JSON},
+		{"text/html, application/xml", RT_XML},
+		{"application/xml, text/html", RT_XML},
+		{"application/json", RT_JSON},
+		{"text/html", RT_HTML},
+		{"", RT_HTML},
+		{"*/*", RT_HTML},
+	}
+
+	for _, c := range cases {
+		if got := negotiateReturnType(c.accept); got != c.want {
+			t.Errorf("negotiateReturnType(%q) = %d, want %d", c.accept, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"text/template"
+)
+
+// GetFuncMap returns the set of functions made available to templates parsed by parseTemplates().
+// csrfField/csrfToken are registered here as placeholders, bound to no session, so that parsing
+// succeeds; Route() clones the parsed template per request and rebinds them to that request's
+// session with csrfFuncMap before executing it. Apps that need additional template helpers should
+// wrap this map and add their own entries.
+func GetFuncMap() template.FuncMap {
+	return csrfFuncMap(nil)
+}
@@ -0,0 +1,65 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// A Field is one piece of structured data attached to a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F builds a Field, for passing structured data to a Logger method: logger.Info("saved user", sawsij.F("id", u.Id)).
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is the interface Route() and Configure() log through. The framework's own log.Printf
+// calls have all been replaced with calls to a package-wide Logger, which defaults to stdLogger
+// but can be swapped out with SetLogger for anything else that satisfies this interface, such as
+// an adapter around zap or zerolog.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// stdLogger is the default Logger, built on the standard library's log package.
+type stdLogger struct {
+	l *log.Logger
+}
+
+// newStdLogger returns a stdLogger writing to out.
+func newStdLogger(out *os.File) *stdLogger {
+	return &stdLogger{l: log.New(out, "", log.LstdFlags)}
+}
+
+func (s *stdLogger) write(level string, msg string, fields []Field) {
+	line := fmt.Sprintf("[%s] %s", level, msg)
+	for _, f := range fields {
+		line += fmt.Sprintf(" %s=%v", f.Key, f.Value)
+	}
+	s.l.Print(line)
+}
+
+func (s *stdLogger) Debug(msg string, fields ...Field) { s.write("DEBUG", msg, fields) }
+func (s *stdLogger) Info(msg string, fields ...Field)  { s.write("INFO", msg, fields) }
+func (s *stdLogger) Warn(msg string, fields ...Field)  { s.write("WARN", msg, fields) }
+func (s *stdLogger) Error(msg string, fields ...Field) { s.write("ERROR", msg, fields) }
+
+// logger is the package-wide Logger used throughout Route() and Configure(). Call SetLogger
+// before Configure() to replace it.
+var logger Logger = newStdLogger(os.Stdout)
+
+// SetLogger replaces the package-wide Logger that Route() and Configure() log through.
+func SetLogger(l Logger) {
+	logger = l
+}
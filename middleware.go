@@ -0,0 +1,154 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Handler is the function signature that Route() and RouteGroup.Route() expect: given the incoming
+// request, the AppScope and the RequestScope, it returns the data to render and/or an error.
+type Handler func(*http.Request, *AppScope, *RequestScope) (HandlerResponse, error)
+
+// Middleware wraps a Handler with additional behaviour, returning a new Handler that calls next
+// somewhere in its body. Middleware is applied via Group() and RouteGroup.Route().
+type Middleware func(next Handler) Handler
+
+// LoggingMiddleware logs the method and path of every request that reaches it, and how the
+// handler resolved (redirect, error or normal response).
+func LoggingMiddleware(next Handler) Handler {
+	return func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		logger.Debug("dispatching request", F("method", r.Method), F("path", r.URL.Path))
+		hr, err := next(r, a, rs)
+		if err != nil {
+			logger.Error("handler returned error", F("method", r.Method), F("path", r.URL.Path), F("error", err))
+		} else if hr.Redirect != "" {
+			logger.Debug("handler redirected", F("method", r.Method), F("path", r.URL.Path), F("redirect", hr.Redirect))
+		} else {
+			logger.Debug("handler ok", F("method", r.Method), F("path", r.URL.Path))
+		}
+		return hr, err
+	}
+}
+
+// RecoveryMiddleware recovers from a panic inside next, logs it and turns it into an error
+// response instead of letting it take down the server.
+func RecoveryMiddleware(next Handler) Handler {
+	return func(r *http.Request, a *AppScope, rs *RequestScope) (hr HandlerResponse, err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				logger.Error("recovered from panic in handler", F("path", r.URL.Path), F("panic", rec))
+				hr = HandlerResponse{}
+				hr.Init()
+				err = fmt.Errorf("panic: %v", rec)
+			}
+		}()
+		return next(r, a, rs)
+	}
+}
+
+// GzipMiddleware marks the RequestScope as compressible when the client sent an Accept-Encoding
+// header that includes gzip. Route() does the actual compression once the handler has returned,
+// since only it holds the http.ResponseWriter.
+func GzipMiddleware(next Handler) Handler {
+	return func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			rs.Compress = true
+		}
+		return next(r, a, rs)
+	}
+}
+
+// RoleMiddleware returns a Middleware that only calls next if the logged-in user (or R_GUEST, if
+// nobody is logged in) has one of the given roles. Otherwise it redirects to the login page (with
+// the request path encoded as dest) or to /denied, as Route()'s own role check does, except that
+// Route() encodes the route's pattern as dest rather than the request path.
+// RoleMiddleware is provided so that role enforcement can be reused outside of RouteConfig.Roles,
+// for example in a group shared by routes that each need a different role set.
+func RoleMiddleware(roles []int) Middleware {
+	return func(next Handler) Handler {
+		return func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+			role := R_GUEST
+			su := rs.Session.Values["user"]
+			if su != nil {
+				u := su.(User)
+				role = int(u.GetRole())
+			}
+
+			if !InArray(role, roles) {
+				var hr HandlerResponse
+				if su == nil {
+					dest := base64.URLEncoding.EncodeToString([]byte(r.URL.Path))
+					hr.Redirect = fmt.Sprintf("/login/dest/%v", dest)
+				} else {
+					hr.Init()
+					hr.Redirect = "/denied"
+				}
+				return hr, nil
+			}
+
+			return next(r, a, rs)
+		}
+	}
+}
+
+// gzipResponseWriter wraps an http.ResponseWriter so that everything written to it is compressed
+// with gzip before it reaches the client.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	w *gzip.Writer
+}
+
+func (g gzipResponseWriter) Write(b []byte) (int, error) {
+	return g.w.Write(b)
+}
+
+// RouteGroup is a set of routes that share a path prefix and a middleware chain. Groups are
+// created with Group() and nested with RouteGroup.Group().
+type RouteGroup struct {
+	prefix     string
+	middleware []Middleware
+}
+
+// Group starts a new RouteGroup rooted at prefix, running middleware (in the order given, outermost
+// first) around every route added to it with RouteGroup.Route().
+func Group(prefix string, middleware ...Middleware) *RouteGroup {
+	return &RouteGroup{prefix: strings.TrimRight(prefix, "/"), middleware: middleware}
+}
+
+// Group starts a nested RouteGroup under g. The child inherits g's prefix and middleware, with its
+// own prefix appended and its own middleware run after g's.
+func (g *RouteGroup) Group(prefix string, middleware ...Middleware) *RouteGroup {
+	childMiddleware := make([]Middleware, 0, len(g.middleware)+len(middleware))
+	childMiddleware = append(childMiddleware, g.middleware...)
+	childMiddleware = append(childMiddleware, middleware...)
+
+	return &RouteGroup{
+		prefix:     g.prefix + strings.TrimRight(prefix, "/"),
+		middleware: childMiddleware,
+	}
+}
+
+// wrapped runs h through the group's middleware, outermost first, so that the first middleware
+// passed to Group()/RouteGroup.Group() is the first to see the request.
+func (g *RouteGroup) wrapped(h Handler) Handler {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		h = g.middleware[i](h)
+	}
+	return h
+}
+
+// Route adds rcfg to the group: its Pattern is prefixed with the group's path, and its Handler is
+// wrapped with the group's middleware (and any nested parent middleware) before being registered
+// with Route(). RouteConfig.Roles still gates access exactly as it does outside of a group.
+func (g *RouteGroup) Route(rcfg RouteConfig) {
+	rcfg.Pattern = g.prefix + rcfg.Pattern
+	rcfg.Handler = g.wrapped(rcfg.Handler)
+	Route(rcfg)
+}
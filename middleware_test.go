@@ -0,0 +1,101 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func markerMiddleware(calls *[]string, name string) Middleware {
+	return func(next Handler) Handler {
+		return func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+			*calls = append(*calls, name)
+			return next(r, a, rs)
+		}
+	}
+}
+
+func TestGroupPrefixAndMiddlewareNesting(t *testing.T) {
+	var calls []string
+
+	parent := Group("/admin", markerMiddleware(&calls, "parent"))
+	child := parent.Group("/users", markerMiddleware(&calls, "child"))
+
+	if child.prefix != "/admin/users" {
+		t.Fatalf("expected prefix %q, got %q", "/admin/users", child.prefix)
+	}
+
+	handler := child.wrapped(func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		calls = append(calls, "handler")
+		return HandlerResponse{}, nil
+	})
+
+	if _, err := handler(nil, nil, &RequestScope{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"parent", "child", "handler"}
+	if len(calls) != len(want) {
+		t.Fatalf("got %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got %v, want %v", calls, want)
+		}
+	}
+}
+
+func TestRoleMiddlewareRedirectsGuestToLogin(t *testing.T) {
+	called := false
+	h := RoleMiddleware([]int{1})(func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		called = true
+		return HandlerResponse{}, nil
+	})
+
+	r, _ := http.NewRequest("GET", "/secret", nil)
+	rs := &RequestScope{Session: newTestSession()}
+
+	hr, err := h(r, &AppScope{}, rs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called {
+		t.Fatal("handler should not have been called for a guest")
+	}
+	if !strings.HasPrefix(hr.Redirect, "/login/dest/") {
+		t.Fatalf("expected a login redirect, got %q", hr.Redirect)
+	}
+}
+
+func TestRoleMiddlewareAllowsPermittedRole(t *testing.T) {
+	called := false
+	h := RoleMiddleware([]int{1})(func(r *http.Request, a *AppScope, rs *RequestScope) (HandlerResponse, error) {
+		called = true
+		return HandlerResponse{}, nil
+	})
+
+	r, _ := http.NewRequest("GET", "/secret", nil)
+	rs := &RequestScope{Session: newTestSession()}
+	rs.Session.Values["user"] = testUser{role: 1}
+
+	if _, err := h(r, &AppScope{}, rs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatal("handler should have been called for a permitted role")
+	}
+}
+
+// testUser is a minimal User for middleware/auth tests.
+type testUser struct {
+	role int64
+}
+
+func (u testUser) TestPassword(password string, a *AppScope) bool { return password == "correct" }
+func (u testUser) GetRole() int64                                 { return u.role }
+func (u testUser) ClearPasswordHash()                             {}
+
@@ -0,0 +1,183 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// schemaVersionTable is the bookkeeping table, one row per schema, that records which migrations
+// have already been applied.
+const schemaVersionTable = "sawsij_schema_version"
+
+// migrationFilename matches "NNN_description.sql", capturing the version number.
+var migrationFilename = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+
+// A Migration is one file under db/migrations/<schema>/, named NNN_description.sql, where NNN is
+// the version it brings the schema to.
+type Migration struct {
+	Version     int64
+	Description string
+	Path        string
+}
+
+// loadMigrations reads db/migrations/<schema> under basePath and returns its migrations sorted by
+// version, ascending.
+func loadMigrations(basePath string, schemaName string) ([]Migration, error) {
+	dir := filepath.Join(basePath, "db", "migrations", schemaName)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		m := migrationFilename.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version:     version,
+			Description: entry.Name(),
+			Path:        filepath.Join(dir, entry.Name()),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// ensureSchemaVersionTable creates the sawsij_schema_version table if it doesn't already exist.
+func ensureSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + schemaVersionTable + ` (
+		schema_name text PRIMARY KEY,
+		version     bigint NOT NULL
+	)`)
+	return err
+}
+
+// currentSchemaVersion returns the version recorded for schemaName in sawsij_schema_version, or 0
+// if the schema has never been migrated.
+func currentSchemaVersion(db *sql.DB, schemaName string) (int64, error) {
+	var version int64
+	err := db.QueryRow("SELECT version FROM "+schemaVersionTable+" WHERE schema_name = $1", schemaName).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// setSchemaVersion records that schemaName is now at version.
+func setSchemaVersion(db *sql.DB, schemaName string, version int64) error {
+	_, err := db.Exec(`INSERT INTO `+schemaVersionTable+` (schema_name, version) VALUES ($1, $2)
+		ON CONFLICT (schema_name) DO UPDATE SET version = $2`, schemaName, version)
+	return err
+}
+
+// MigrateSchema brings schemaName from its current version up to target, applying each pending
+// migration under db/migrations/<schema> in order inside its own transaction. It's a no-op if the
+// schema is already at or past target, and an error if the migrations run out before reaching it.
+func MigrateSchema(db *sql.DB, basePath string, schemaName string, target int64) error {
+	if err := ensureSchemaVersionTable(db); err != nil {
+		return err
+	}
+
+	current, err := currentSchemaVersion(db, schemaName)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(basePath, schemaName)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if m.Version > target {
+			break
+		}
+
+		logger.Info("applying migration", F("migration", m.Description), F("schema", schemaName))
+
+		sqlBytes, err := ioutil.ReadFile(m.Path)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(sqlBytes)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %v failed: %v", m.Description, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		if err := setSchemaVersion(db, schemaName, m.Version); err != nil {
+			return err
+		}
+		current = m.Version
+	}
+
+	if current < target {
+		return fmt.Errorf("schema %q is at version %v, but no migration under db/migrations/%v brings it to %v", schemaName, current, schemaName, target)
+	}
+
+	return nil
+}
+
+// VerifySchemas checks that every schema in a.Db.Schemas is at its expected version. When
+// autoMigrate is true, pending migrations are applied to bring it up to date; otherwise a mismatch
+// is returned as an error so the application can fail fast instead of running against a schema the
+// code doesn't expect.
+func VerifySchemas(a *AppScope, autoMigrate bool) error {
+	for _, schema := range a.Db.Schemas {
+		if err := ensureSchemaVersionTable(a.Db.Db); err != nil {
+			return err
+		}
+
+		current, err := currentSchemaVersion(a.Db.Db, schema.Name)
+		if err != nil {
+			return err
+		}
+
+		if current == schema.Version {
+			continue
+		}
+
+		if current > schema.Version {
+			return fmt.Errorf("schema %q is at version %v, newer than the %v the code expects", schema.Name, current, schema.Version)
+		}
+
+		if !autoMigrate {
+			return fmt.Errorf("schema %q is at version %v, code expects %v; run sawsij-migrate or set database.autoMigrate: true", schema.Name, current, schema.Version)
+		}
+
+		logger.Info("migrating schema", F("schema", schema.Name), F("from", current), F("to", schema.Version))
+		if err := MigrateSchema(a.Db.Db, a.BasePath, schema.Name, schema.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
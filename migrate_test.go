@@ -0,0 +1,53 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigrationsOrdersByVersionAndIgnoresNonSQL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sawsij-migrations")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	schemaDir := filepath.Join(dir, "db", "migrations", "public")
+	if err := os.MkdirAll(schemaDir, 0755); err != nil {
+		t.Fatalf("creating schema dir: %v", err)
+	}
+
+	for _, f := range []string{"002_add_index.sql", "001_create_users.sql", "010_add_column.sql", "README.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(schemaDir, f), []byte("-- noop"), 0644); err != nil {
+			t.Fatalf("writing %v: %v", f, err)
+		}
+	}
+
+	migrations, err := loadMigrations(dir, "public")
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+
+	if len(migrations) != 3 {
+		t.Fatalf("expected 3 migrations (README.txt excluded), got %d: %+v", len(migrations), migrations)
+	}
+
+	wantVersions := []int64{1, 2, 10}
+	for i, m := range migrations {
+		if m.Version != wantVersions[i] {
+			t.Fatalf("migration %d: got version %d, want %d (order: %+v)", i, m.Version, wantVersions[i], migrations)
+		}
+	}
+}
+
+func TestLoadMigrationsMissingDir(t *testing.T) {
+	if _, err := loadMigrations("/does/not/exist", "public"); err == nil {
+		t.Fatal("expected an error for a missing migrations directory")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"time"
+)
+
+// redisSessionMaxAge is how long a session (and the cookie that references it) lives, in seconds.
+const redisSessionMaxAge = 86400 * 30
+
+// redisStore is a sessions.Store that keeps session data in Redis, identified by a random session
+// ID, with only that ID (signed the same way CookieStore signs a whole session) kept in the
+// client's cookie. Third-party stores like boj/redistore are built against a different major
+// version of gorilla/sessions than this framework's go.mod requires, so their *RediStore doesn't
+// satisfy our sessions.Store interface — this implementation talks to Redis directly so it does.
+type redisStore struct {
+	pool   *redis.Pool
+	codecs []securecookie.Codec
+}
+
+// newRedisStore returns a redisStore connected to addr (and authenticated with password, if it's
+// non-empty), keyed with keys the same way NewSessionStore keys the cookie and filesystem stores.
+func newRedisStore(addr string, password string, keys [][]byte) *redisStore {
+	return &redisStore{
+		pool: &redis.Pool{
+			MaxIdle:     10,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				conn, err := redis.Dial("tcp", addr)
+				if err != nil {
+					return nil, err
+				}
+				if password != "" {
+					if _, err := conn.Do("AUTH", password); err != nil {
+						conn.Close()
+						return nil, err
+					}
+				}
+				return conn, nil
+			},
+		},
+		codecs: securecookie.CodecsFromPairs(keys...),
+	}
+}
+
+// Get returns the session named name for r, or a new, empty one if there's no cookie for it, or
+// the cookie's session has expired or been evicted from Redis.
+func (rs *redisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(rs, name)
+	session.Options = &sessions.Options{Path: "/", MaxAge: redisSessionMaxAge}
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var sessionID string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &sessionID, rs.codecs...); err != nil {
+		return session, nil
+	}
+
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", "session:"+sessionID))
+	if err != nil {
+		return session, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, nil
+	}
+
+	session.ID = sessionID
+	session.IsNew = false
+	return session, nil
+}
+
+// New always returns a fresh, empty session named name, ignoring any cookie already on r.
+func (rs *redisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(rs, name)
+	session.Options = &sessions.Options{Path: "/", MaxAge: redisSessionMaxAge}
+	session.IsNew = true
+	return session, nil
+}
+
+// Save writes session's values to Redis under a (possibly new) session ID, and sets a cookie on w
+// referencing that ID.
+func (rs *redisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		session.ID = newCSRFToken() // reuse the same random-token generator used for CSRF tokens
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return err
+	}
+
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("SETEX", "session:"+session.ID, redisSessionMaxAge, buf.Bytes()); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, rs.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
@@ -6,17 +6,18 @@
 package sawsij
 
 import (
-	"code.google.com/p/gorilla/sessions"
+	"bytes"
+	"compress/gzip"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	_ "github.com/bmizerany/pq"
+	"github.com/gorilla/sessions"
 	"github.com/kylelemons/go-gypsy/yaml"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -51,6 +52,9 @@ type Schema struct {
 type RequestScope struct {
 	Session   *sessions.Session
 	UrlParams map[string]string
+	// Compress is set by GzipMiddleware to indicate that the client accepts a gzip-encoded
+	// response. Route() does the actual compression once the handler has returned.
+	Compress bool
 }
 
 // The User interface describes the methods that the framework needs to interact with a user for the purposes of auth and session management. 
@@ -71,9 +75,18 @@ type User interface {
 // session mangement.
 type AppSetup struct {
 	GetUser func(username string, a *AppScope) User
+	// PreMigrate, if set, is called after the database connection is opened but before schema
+	// versions are checked or any migration runs. PostMigrate, if set, is called afterwards, once
+	// every schema is confirmed to be at its expected version. Both are useful for things like
+	// audit logging around a migration.
+	PreMigrate  func(a *AppScope) error
+	PostMigrate func(a *AppScope) error
+	// PostLoginHook, if set, is called by LoginHandler right after a successful login, for
+	// things like audit logging or handing off to an MFA step.
+	PostLoginHook func(u User, a *AppScope)
 }
 
-var store *sessions.CookieStore
+var store sessions.Store
 var appScope *AppScope
 var parsedTemplate *template.Template
 
@@ -81,12 +94,12 @@ func parseTemplates() {
 	viewPath := appScope.BasePath + "/templates"
 	templateDir, err := os.Open(viewPath)
 	if err != nil {
-		log.Print(err)
+		logger.Error("opening template dir", F("error", err))
 	}
 
 	allFiles, err := templateDir.Readdirnames(0)
 	if err != nil {
-		log.Print(err)
+		logger.Error("reading template dir", F("error", err))
 	}
 	templateExt := "html"
 	var templateFiles []string
@@ -98,12 +111,12 @@ func parseTemplates() {
 			}
 		}
 	}
-	log.Printf("Templates: %v", templateFiles)
+	logger.Debug("parsed templates", F("files", templateFiles))
 	if len(templateFiles) > 0 {
 		pt, err := template.New("dummy").Delims("<%", "%>").Funcs(GetFuncMap()).ParseFiles(templateFiles...)
 		parsedTemplate = pt
 		if err != nil {
-			log.Print(err)
+			logger.Error("parsing templates", F("error", err))
 		}
 	}
 }
@@ -125,8 +138,16 @@ func (h *HandlerResponse) Init() {
 // RouteConfig is what is supplied to the Route() function to set up a route. More about how this is used in the documentation for the Route function.
 type RouteConfig struct {
 	Pattern string
-	Handler func(*http.Request, *AppScope, *RequestScope) (HandlerResponse, error)
+	Handler Handler
 	Roles   []int
+	// SkipCSRF disables CSRF token enforcement on this route's non-GET/HEAD requests. Set this
+	// for JSON APIs that authenticate with a bearer token instead of the session cookie.
+	SkipCSRF bool
+	// DefaultReturnType, when set, is used whenever the URL has no "/json" or "/xml" prefix, in
+	// place of negotiating on the Accept header, so an API route can default to JSON (or XML)
+	// regardless of how it's requested. Leave it as RT_HTML (the zero value) to keep the normal
+	// negotiate-or-fall-back-to-HTML behaviour.
+	DefaultReturnType int
 	// TODO Allow explicit configuration of response type (JSON/XML/Etc) (issue #4)
 	// TODO Allow specification of url params /value/value/value or /key/value/key/value/key/value (issue #5)
 }
@@ -155,41 +176,38 @@ func Route(rcfg RouteConfig) {
 	var slashRoute string = ""
 	if p := strings.LastIndex(rcfg.Pattern, "/"); p != len(rcfg.Pattern)-1 {
 		slashRoute = rcfg.Pattern + "/"
-		log.Printf("Specified %q, implying %q", rcfg.Pattern, slashRoute)
+		logger.Debug("implied slash route", F("pattern", rcfg.Pattern), F("slashRoute", slashRoute))
 	}
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Request method from handler: %q", r.Method)
-
 		cacheTemplates, err := appScope.Config.Get("server.cacheTemplates")
 		if err != nil {
-			log.Print(err)
+			logger.Error("reading server.cacheTemplates", F("error", err))
 		} else {
 			if cacheTemplates != "true" {
 				parseTemplates()
 			}
 		}
 
-		log.Printf("URL path: %v", r.URL.Path)
-		returnType, restOfUrl := GetReturnType(r.URL.Path)
+		returnType, restOfUrl := GetReturnTypeForRequest(r, rcfg.DefaultReturnType)
 
 		urlParams := GetUrlParams(rcfg.Pattern, restOfUrl)
-		log.Printf("URL vars: %v", urlParams)
 		global := make(map[string]interface{})
 		session, _ := store.Get(r, "session")
 		role := R_GUEST // Set to guest by default
 		su := session.Values["user"]
 
-		log.Printf("User: %+v", su)
-		log.Printf("Session vals: %+v", session.Values)
 		if su != nil {
 			u := su.(User)
 			role = int(u.GetRole())
 		}
 
-		log.Printf("pattern: %v roles that can see this: %v user role: %v", rcfg.Pattern, rcfg.Roles, role)
+		logger.Debug("dispatching request",
+			F("method", r.Method), F("path", r.URL.Path), F("urlParams", urlParams),
+			F("pattern", rcfg.Pattern), F("roles", rcfg.Roles), F("role", role))
 
 		var handlerResults HandlerResponse
+		reqScope := RequestScope{UrlParams: urlParams, Session: session}
 
 		if !InArray(role, rcfg.Roles) {
 			// This user does not have the right role
@@ -202,42 +220,45 @@ func Route(rcfg RouteConfig) {
 				handlerResults.Redirect = "/denied"
 				handlerResults.Init()
 			}
+		} else if !rcfg.SkipCSRF && !isSafeMethod(r) && !checkCSRF(r, session) {
+			// Non-GET/HEAD request with a missing or invalid CSRF token.
+			logger.Warn("CSRF check failed, rejecting request", F("path", r.URL.Path))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			session.Save(r, w)
+			return
 		} else {
 			// Everything is ok. Proceed normally.
-			reqScope := RequestScope{UrlParams: urlParams, Session: session}
 			global["user"] = session.Values["user"]
 			// Call the supplied handler function and get the results back.
 			handlerResults, err = rcfg.Handler(r, appScope, &reqScope)
+			// Mint the CSRF token now, before the session is saved and signed into the cookie.
+			// csrfFuncMap (bound below, for the template render) mints one lazily on first use,
+			// which happens after this Save — too late for that token to make it into the
+			// cookie the client walks away with, so it can never be echoed back on the next
+			// POST and checkCSRF would reject every first-time form submission.
+			getCSRFToken(session)
 			reqScope.Session.Save(r, w)
 		}
 
+		if reqScope.Compress {
+			gzw := gzip.NewWriter(w)
+			defer gzw.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			w = gzipResponseWriter{ResponseWriter: w, w: gzw}
+		}
+
 		if handlerResults.Redirect != "" {
 			http.Redirect(w, r, handlerResults.Redirect, http.StatusFound)
 		} else {
 
 			if err != nil {
-				log.Print(err)
+				logger.Error("handler returned error", F("path", r.URL.Path), F("error", err))
 				http.Error(w, "An error occured. See log for details.", http.StatusInternalServerError)
 			} else {
 				switch returnType {
-				case RT_XML:
-					//TODO Return actual XML here (issue #6)
-					w.Header().Set("Content-Type", "text/xml")
-					fmt.Fprintf(w, "%s", xml.Header)
-					log.Print("returning xml")
-					type Response struct {
-						Error string
-					}
-					r := Response{Error: "NOT YET IMPLEMENTED"}
-					b, err := xml.Marshal(r)
-					if err != nil {
-						log.Print(err)
-					} else {
-						fmt.Fprintf(w, "%s", b)
-					}
-				case RT_JSON:
-					w.Header().Set("Content-Type", "application/json")
-					log.Print("returning json")
+				case RT_XML, RT_JSON:
+					mimeType := rtMimeTypes[returnType]
+					w.Header().Set("Content-Type", mimeType)
 
 					var iToRender interface{}
 					if len(handlerResults.View) == 1 {
@@ -249,18 +270,21 @@ func Route(rcfg RouteConfig) {
 								keystring = key
 							}
 						}
-						log.Printf("handler returned single value array. returning value of %q", keystring)
+						logger.Debug("unwrapped single-value view", F("key", keystring))
 
 						iToRender = handlerResults.View[keystring]
 					} else {
 						iToRender = handlerResults.View
 					}
 
-					b, err := json.Marshal(iToRender)
-					if err != nil {
-						log.Print(err)
+					// Encode into a buffer first: if it fails partway through, nothing has been
+					// written to w yet, so the client gets a proper 500 instead of a blank 200.
+					var buf bytes.Buffer
+					if err := encoders[mimeType](&buf, iToRender); err != nil {
+						logger.Error("encoding response", F("mimeType", mimeType), F("error", err))
+						http.Error(w, "An error occured. See log for details.", http.StatusInternalServerError)
 					} else {
-						fmt.Fprintf(w, "%s", b)
+						w.Write(buf.Bytes())
 					}
 				default:
 					templateFilename := templateId + ".html"
@@ -268,9 +292,21 @@ func Route(rcfg RouteConfig) {
 					if len(global) > 0 && handlerResults.View != nil {
 						handlerResults.View["global"] = global
 					}
-					err = parsedTemplate.ExecuteTemplate(w, templateFilename, handlerResults.View)
+
+					// Clone the shared template and bind csrfField/csrfToken to this request's
+					// session, rather than touching parsedTemplate (shared by every concurrent
+					// request) directly.
+					renderTemplate, cloneErr := parsedTemplate.Clone()
+					if cloneErr != nil {
+						logger.Error("cloning template", F("error", cloneErr))
+						http.Error(w, "An error occured. See log for details.", http.StatusInternalServerError)
+						return
+					}
+					renderTemplate = renderTemplate.Funcs(csrfFuncMap(session))
+
+					err = renderTemplate.ExecuteTemplate(w, templateFilename, handlerResults.View)
 					if err != nil {
-						log.Print(err)
+						logger.Error("executing template", F("template", templateFilename), F("error", err))
 					}
 				}
 			}
@@ -278,17 +314,17 @@ func Route(rcfg RouteConfig) {
 		}
 	}
 
-	http.HandleFunc(rcfg.Pattern, fn)
+	http.HandleFunc(rcfg.Pattern, withAccessLog(fn))
 
 	if slashRoute != "" {
-		http.HandleFunc(slashRoute, fn)
+		http.HandleFunc(slashRoute, withAccessLog(fn))
 	}
 
 	return
 }
 
 func staticHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Serving static resource %q - method: %q", r.URL.Path, r.Method)
+	logger.Debug("serving static resource", F("path", r.URL.Path), F("method", r.Method))
 	http.ServeFile(w, r, appScope.BasePath+r.URL.Path)
 }
 
@@ -300,7 +336,7 @@ func Configure(as *AppSetup, basePath string) (err error) {
 
 	a := AppScope{Setup: as}
 	appScope = &a
-	log.Printf("Basepath is currently %q", basePath)
+	logger.Debug("configuring app", F("basePath", basePath))
 	if basePath == "" {
 
 		if len(os.Args) == 1 {
@@ -314,7 +350,7 @@ func Configure(as *AppSetup, basePath string) (err error) {
 
 	configFilename := appScope.BasePath + "/etc/config.yaml"
 
-	log.Print("Using config file [" + configFilename + "]")
+	logger.Info("using config file", F("file", configFilename))
 
 	c, err := yaml.ReadFile(configFilename)
 	if err != nil {
@@ -338,16 +374,21 @@ func Configure(as *AppSetup, basePath string) (err error) {
 	
 	schemasN, err := yaml.Child(c.Root, ".database.schemas")
 	if err != nil {
-		log.Print(err)
+		logger.Error("reading database.schemas", F("error", err))
 	}
 	var aSchs []Schema
 	if schemasN != nil {
 		schemas := schemasN.(yaml.Map)
-		
+
 		for schema, version := range schemas {
-			log.Printf("Schema: %v - Version: %v", schema, version)
-			
-			aSchs = append(aSchs,Schema{Name: string(schema) ,Version: 2})
+			logger.Debug("found schema", F("schema", schema), F("version", version))
+
+			versionInt, err := strconv.ParseInt(strings.TrimSpace(string(version.(yaml.Scalar))), 10, 64)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			aSchs = append(aSchs, Schema{Name: string(schema), Version: versionInt})
 		}
 	} else {
 		log.Fatal("No schemas defined in config.yaml")
@@ -358,18 +399,32 @@ func Configure(as *AppSetup, basePath string) (err error) {
 		log.Fatal(err)
 	}
 	appScope.Db = &DbSetup{Db: db,DefaultSchema: defaultSchema,Schemas: aSchs}
-	log.Printf("Db: %+v",appScope.Db)
-	// TODO Check to see that database version matches the version specified in the code. Throw error and do not start. (issue #7)
+	logger.Debug("connected to database", F("db", appScope.Db))
 
-	key, err := c.Get("encryption.key")
+	if as.PreMigrate != nil {
+		if err := as.PreMigrate(appScope); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	autoMigrate, _ := c.Get("database.autoMigrate")
+	if err := VerifySchemas(appScope, autoMigrate == "true"); err != nil {
+		log.Fatal(err)
+	}
+
+	if as.PostMigrate != nil {
+		if err := as.PostMigrate(appScope); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	store, err = NewSessionStore(c, appScope.BasePath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	store = sessions.NewCookieStore([]byte(key))
 
-	
-	log.Print("Static dir is [" + appScope.BasePath + "/static" + "]")
+	logger.Info("serving static files", F("dir", appScope.BasePath+"/static"))
 	http.HandleFunc("/static/", staticHandler)
 
 	parseTemplates()
@@ -385,6 +440,6 @@ func Run() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Print("Listening on port [" + port + "]")
+	logger.Info("listening", F("port", port))
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", port), nil))
 }
@@ -0,0 +1,81 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"fmt"
+	"github.com/gorilla/sessions"
+	"github.com/kylelemons/go-gypsy/yaml"
+)
+
+// NewSessionStore builds the sessions.Store to use for the app, based on the session.store config
+// key ("cookie", "fs" or "redis"; "cookie" if unset). Whichever backend is chosen, it's keyed with
+// every key under encryption.keys (falling back to the single encryption.key for apps that haven't
+// moved to the list form yet): the first key signs new sessions, and all of them are tried when
+// verifying an existing one, so keys can be rotated without invalidating every session at once.
+func NewSessionStore(c *yaml.File, basePath string) (sessions.Store, error) {
+	backend, err := c.Get("session.store")
+	if err != nil || backend == "" {
+		backend = "cookie"
+	}
+
+	keys, err := getEncryptionKeys(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stores take hash/block key pairs; pairing each key with a nil block key makes every
+	// configured key a signing key rather than treating every second one as an encryption key.
+	var keyPairs [][]byte
+	for _, k := range keys {
+		keyPairs = append(keyPairs, k, nil)
+	}
+
+	switch backend {
+	case "cookie":
+		return sessions.NewCookieStore(keyPairs...), nil
+	case "fs":
+		path, err := c.Get("session.fs.path")
+		if err != nil {
+			return nil, fmt.Errorf("session.store is \"fs\" but session.fs.path isn't set: %v", err)
+		}
+		return sessions.NewFilesystemStore(path, keyPairs...), nil
+	case "redis":
+		addr, err := c.Get("session.redis.addr")
+		if err != nil {
+			return nil, fmt.Errorf("session.store is \"redis\" but session.redis.addr isn't set: %v", err)
+		}
+		password, _ := c.Get("session.redis.password")
+		return newRedisStore(addr, password, keyPairs), nil
+	default:
+		return nil, fmt.Errorf("unknown session.store %q, want cookie, fs or redis", backend)
+	}
+}
+
+// getEncryptionKeys reads the keys used to sign sessions, preferring the
+// encryption.keys list so that rotation can drop old keys over time, and falling back to the
+// single encryption.key for apps configured the old way.
+func getEncryptionKeys(c *yaml.File) ([][]byte, error) {
+	keysNode, err := yaml.Child(c.Root, ".encryption.keys")
+	if err == nil && keysNode != nil {
+		if list, ok := keysNode.(yaml.List); ok {
+			var keys [][]byte
+			for _, n := range list {
+				if s, ok := n.(yaml.Scalar); ok {
+					keys = append(keys, []byte(string(s)))
+				}
+			}
+			if len(keys) > 0 {
+				return keys, nil
+			}
+		}
+	}
+
+	key, err := c.Get("encryption.key")
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{[]byte(key)}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import (
+	"github.com/kylelemons/go-gypsy/yaml"
+	"testing"
+)
+
+func TestGetEncryptionKeysPrefersList(t *testing.T) {
+	c := yaml.Config(`
+encryption:
+  key: old-single-key
+  keys:
+    - new-key-one
+    - new-key-two
+`)
+
+	keys, err := getEncryptionKeys(c)
+	if err != nil {
+		t.Fatalf("getEncryptionKeys: %v", err)
+	}
+
+	if len(keys) != 2 || string(keys[0]) != "new-key-one" || string(keys[1]) != "new-key-two" {
+		t.Fatalf("got %+v, want [new-key-one new-key-two]", keys)
+	}
+}
+
+func TestGetEncryptionKeysFallsBackToSingleKey(t *testing.T) {
+	c := yaml.Config(`
+encryption:
+  key: old-single-key
+`)
+
+	keys, err := getEncryptionKeys(c)
+	if err != nil {
+		t.Fatalf("getEncryptionKeys: %v", err)
+	}
+
+	if len(keys) != 1 || string(keys[0]) != "old-single-key" {
+		t.Fatalf("got %+v, want [old-single-key]", keys)
+	}
+}
+
+func TestGetEncryptionKeysMissing(t *testing.T) {
+	c := yaml.Config(`session: {}`)
+
+	if _, err := getEncryptionKeys(c); err == nil {
+		t.Fatal("expected an error when neither encryption.keys nor encryption.key is set")
+	}
+}
+
+func TestNewSessionStoreDefaultsToCookie(t *testing.T) {
+	c := yaml.Config(`
+encryption:
+  key: some-key
+`)
+
+	store, err := NewSessionStore(c, "")
+	if err != nil {
+		t.Fatalf("NewSessionStore: %v", err)
+	}
+	if store == nil {
+		t.Fatal("expected a non-nil store")
+	}
+}
+
+func TestNewSessionStoreRedisRequiresAddr(t *testing.T) {
+	c := yaml.Config(`
+encryption:
+  key: some-key
+session:
+  store: redis
+`)
+
+	if _, err := NewSessionStore(c, ""); err == nil {
+		t.Fatal("expected an error when session.store is redis but session.redis.addr isn't set")
+	}
+}
+
+func TestNewSessionStoreRedis(t *testing.T) {
+	c := yaml.Config(`
+encryption:
+  key: some-key
+session:
+  store: redis
+  redis:
+    addr: localhost:6379
+`)
+
+	store, err := NewSessionStore(c, "")
+	if err != nil {
+		t.Fatalf("NewSessionStore: %v", err)
+	}
+	if _, ok := store.(*redisStore); !ok {
+		t.Fatalf("got %T, want *redisStore", store)
+	}
+}
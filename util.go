@@ -0,0 +1,52 @@
+// Copyright 2012 J. William McCarthy. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sawsij
+
+import "strings"
+
+// InArray reports whether needle is present in haystack. An empty haystack matches anything,
+// since that's how Route() and RoleMiddleware treat a RouteConfig/roles list that wasn't set:
+// no roles named means no restriction.
+func InArray(needle int, haystack []int) bool {
+	if len(haystack) == 0 {
+		return true
+	}
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTemplateName turns a route pattern into the template file it should render, as described on
+// Route(): slashes become dashes, and the root pattern "/" maps to "index".
+func GetTemplateName(pattern string) string {
+	if pattern == "/" {
+		return "index"
+	}
+	return strings.Replace(strings.Trim(pattern, "/"), "/", "-", -1)
+}
+
+// GetUrlParams pairs up the path segments of restOfUrl that come after pattern into a param map,
+// as described on Route(): pattern "/admin/" against "/admin/id/14/display/1" yields
+// {"id": "14", "display": "1"}. restOfUrl has had any "/json" or "/xml" prefix removed by
+// GetReturnType, so the same prefix is removed from pattern before matching.
+func GetUrlParams(pattern string, restOfUrl string) map[string]string {
+	params := make(map[string]string)
+	_, pattern = GetReturnType(pattern)
+
+	rest := strings.Trim(strings.TrimPrefix(restOfUrl, pattern), "/")
+	if rest == "" {
+		return params
+	}
+
+	parts := strings.Split(rest, "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		params[parts[i]] = parts[i+1]
+	}
+
+	return params
+}